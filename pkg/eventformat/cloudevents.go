@@ -0,0 +1,62 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package eventformat provides sink-agnostic helpers for re-encoding a
+// kubernetes *v1.Event into wire formats other than each sink's bespoke
+// default, so multiple sinks can share the same envelope logic.
+package eventformat
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/api/core/v1"
+)
+
+// CloudEventsSpecVersion is the CloudEvents spec version produced by NewCloudEvent.
+const CloudEventsSpecVersion = "1.0"
+
+// CloudEvent is a structured-mode CloudEvents v1.0 JSON envelope around a
+// kubernetes event, as described by
+// https://github.com/cloudevents/spec/blob/v1.0/spec.md
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	Type            string    `json:"type"`
+	Source          string    `json:"source"`
+	ID              string    `json:"id"`
+	Time            string    `json:"time,omitempty"`
+	Subject         string    `json:"subject,omitempty"`
+	DataContentType string    `json:"datacontenttype,omitempty"`
+	Data            *v1.Event `json:"data"`
+}
+
+// NewCloudEvent wraps event into a CloudEvents v1.0 envelope.
+func NewCloudEvent(event *v1.Event) *CloudEvent {
+	return &CloudEvent{
+		SpecVersion:     CloudEventsSpecVersion,
+		Type:            fmt.Sprintf("io.k8s.core.v1.event.%s", event.Reason),
+		Source:          fmt.Sprintf("/apis/v1/namespaces/%s/events/%s", event.Namespace, event.Name),
+		ID:              string(event.UID),
+		Time:            event.LastTimestamp.Format(time.RFC3339Nano),
+		Subject:         fmt.Sprintf("%s/%s", event.InvolvedObject.Kind, event.InvolvedObject.Name),
+		DataContentType: "application/json",
+		Data:            event,
+	}
+}
+
+// JSON marshals the envelope to its structured-mode JSON representation.
+func (c *CloudEvent) JSON() ([]byte, error) {
+	return json.Marshal(c)
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package eventformat
+
+import (
+	"encoding/json"
+	"testing"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestNewCloudEvent(t *testing.T) {
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			UID: types.UID("abc-123"),
+		},
+		Reason:    "CrashLoopBackOff",
+		Namespace: "kube-system",
+		Name:      "pod-1.16f1a2b3c4d5e6f7",
+	}
+	event.InvolvedObject.Kind = "Pod"
+	event.InvolvedObject.Name = "pod-1"
+
+	ce := NewCloudEvent(event)
+
+	if ce.SpecVersion != CloudEventsSpecVersion {
+		t.Errorf("SpecVersion = %q, want %q", ce.SpecVersion, CloudEventsSpecVersion)
+	}
+	if want := "io.k8s.core.v1.event.CrashLoopBackOff"; ce.Type != want {
+		t.Errorf("Type = %q, want %q", ce.Type, want)
+	}
+	if want := "/apis/v1/namespaces/kube-system/events/pod-1.16f1a2b3c4d5e6f7"; ce.Source != want {
+		t.Errorf("Source = %q, want %q", ce.Source, want)
+	}
+	if ce.ID != "abc-123" {
+		t.Errorf("ID = %q, want %q", ce.ID, "abc-123")
+	}
+	if want := "Pod/pod-1"; ce.Subject != want {
+		t.Errorf("Subject = %q, want %q", ce.Subject, want)
+	}
+	if ce.DataContentType != "application/json" {
+		t.Errorf("DataContentType = %q, want %q", ce.DataContentType, "application/json")
+	}
+	if ce.Data != event {
+		t.Error("Data does not reference the wrapped event")
+	}
+}
+
+func TestCloudEventJSON(t *testing.T) {
+	event := &v1.Event{Reason: "Started"}
+	event.InvolvedObject.Kind = "Pod"
+	event.InvolvedObject.Name = "pod-1"
+
+	b, err := NewCloudEvent(event).JSON()
+	if err != nil {
+		t.Fatalf("JSON() returned error: %v", err)
+	}
+
+	var decoded map[string]interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("failed to unmarshal envelope: %v", err)
+	}
+	if decoded["specversion"] != CloudEventsSpecVersion {
+		t.Errorf("decoded specversion = %v, want %q", decoded["specversion"], CloudEventsSpecVersion)
+	}
+	if _, ok := decoded["data"]; !ok {
+		t.Error("decoded envelope is missing the data field")
+	}
+}
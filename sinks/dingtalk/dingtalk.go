@@ -16,14 +16,24 @@ package dingtalk
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"text/template"
 	"time"
 
 	"github.com/AliyunContainerService/kube-eventer/core"
+	"github.com/AliyunContainerService/kube-eventer/pkg/eventformat"
+	"golang.org/x/time/rate"
 	"k8s.io/api/core/v1"
 	"k8s.io/klog"
 )
@@ -35,11 +45,21 @@ const (
 	DEFAULT_MSG_TYPE      = "text"
 	CONTENT_TYPE_JSON     = "application/json"
 	LABE_TEMPLATE         = "%s\n"
+
+	MSG_TYPE_TEXT        = "text"
+	MSG_TYPE_MARKDOWN    = "markdown"
+	MSG_TYPE_LINK        = "link"
+	MSG_TYPE_ACTION_CARD = "actionCard"
+
+	DEFAULT_FORMAT     = "plain"
+	FORMAT_CLOUDEVENTS = "cloudevents"
 )
 
 var (
 	MSG_TEMPLATE = "Level:%s \nKind:%s \nNamespace:%s \nName:%s \nReason:%s \nTimestamp:%s \nMessage:%s"
 
+	MARKDOWN_MSG_TEMPLATE = "#### %s\n- **Level:** %s\n- **Kind:** %s\n- **Namespace:** %s\n- **Name:** %s\n- **Timestamp:** %s\n- **Message:** %s"
+
 	MSG_TEMPLATE_ARR = [][]string{
 		{"Level"},
 		{"Kind"},
@@ -55,28 +75,86 @@ var (
 dingtalk msg struct
 */
 type DingTalkMsg struct {
-	MsgType string       `json:"msgtype"`
-	Text    DingTalkText `json:"text"`
+	MsgType    string              `json:"msgtype"`
+	Text       *DingTalkText       `json:"text,omitempty"`
+	Markdown   *DingTalkMarkdown   `json:"markdown,omitempty"`
+	Link       *DingTalkLink       `json:"link,omitempty"`
+	ActionCard *DingTalkActionCard `json:"actionCard,omitempty"`
+	At         *DingTalkAt         `json:"at,omitempty"`
 }
 
 type DingTalkText struct {
 	Content string `json:"content"`
 }
 
+type DingTalkMarkdown struct {
+	Title string `json:"title"`
+	Text  string `json:"text"`
+}
+
+type DingTalkLink struct {
+	Title      string `json:"title"`
+	Text       string `json:"text"`
+	PicUrl     string `json:"picUrl,omitempty"`
+	MessageUrl string `json:"messageUrl"`
+}
+
+type DingTalkActionCard struct {
+	Title       string `json:"title"`
+	Text        string `json:"text"`
+	SingleTitle string `json:"singleTitle,omitempty"`
+	SingleURL   string `json:"singleURL,omitempty"`
+}
+
+type DingTalkAt struct {
+	AtMobiles []string `json:"atMobiles,omitempty"`
+	IsAtAll   bool     `json:"isAtAll,omitempty"`
+}
+
 /**
 dingtalk sink usage
 --sink:dingtalk:https://oapi.dingtalk.com/robot/send?access_token=[access_token]&level=Warning&label=[label]
 
 level: Normal or Warning. The event level greater than global level will emit.
 label: some thing unique when you want to distinguish different k8s clusters.
+msg_type: text(default), markdown, link or actionCard.
+template/template_file: a text/template template with access to the full *v1.Event, used as the message body.
+link_url/link_url_file: required when msg_type=link; a text/template template with access to the full
+  *v1.Event, used as the message's target messageUrl.
+at_mobiles: comma separated mobile numbers to ping in the DingTalk "at" block.
+at_all: true to ping everyone in the group for matching events.
+secret/secret_file: the robot's signing secret, required when the "additional signing" security policy is enabled.
+format: plain(default) or cloudevents, to wrap the message body as a CloudEvents v1.0 JSON envelope.
+rate/burst: token bucket sizing (messages/sec, burst size) used to stay under DingTalk's per-robot throttle.
+window: how long to coalesce repeated (involvedObject.uid, reason) events before sending, e.g. "30s".
+max_batch: how many coalesced events to render into a single message instead of one POST per event.
+routes_file: path to a YAML file fanning one sink out to multiple DingTalk webhooks by match rules
+  (namespace/kind/reason/level/label selector), see Router/RouteConfig in router.go. When set, every
+  other option above is ignored here and configured per-route instead.
 */
 type DingTalkSink struct {
 	Endpoint   string
 	Namespaces []string
 	Kinds      []string
 	Token      string
+	Secret     string
 	Level      int
 	Labels     []string
+	MsgType    string
+	Format     string
+	Template   *template.Template
+	LinkURL    *template.Template
+	AtMobiles  []string
+	AtAll      bool
+
+	Limiter  *rate.Limiter
+	Window   time.Duration
+	MaxBatch int
+
+	mu      sync.Mutex
+	pending map[string]*coalescedEvent
+
+	Router *Router
 }
 
 func (d *DingTalkSink) Name() string {
@@ -84,17 +162,116 @@ func (d *DingTalkSink) Name() string {
 }
 
 func (d *DingTalkSink) Stop() {
-	//do nothing
+	if d.Router != nil {
+		d.Router.Stop()
+	}
 }
 
 func (d *DingTalkSink) ExportEvents(batch *core.EventBatch) {
+	if d.Router != nil {
+		d.Router.ExportEvents(batch)
+		return
+	}
+
+	d.mu.Lock()
+	if d.pending == nil {
+		d.pending = make(map[string]*coalescedEvent)
+	}
 	for _, event := range batch.Events {
 		if d.isEventLevelDangerous(event.Type) {
-			d.Ding(event)
-			// add threshold
-			time.Sleep(time.Millisecond * 50)
+			d.coalesce(event)
+		}
+	}
+	d.mu.Unlock()
+
+	d.flush()
+}
+
+// coalescedEvent tracks how many times an (involvedObject.UID, Reason) pair has
+// repeated within the configured window, so a CrashLoopBackOff storm collapses
+// into a single message instead of one POST per occurrence.
+type coalescedEvent struct {
+	Event *v1.Event
+	Count int
+	First time.Time
+	Last  time.Time
+}
+
+// coalesce folds event into the pending (UID, Reason) bucket, creating one if
+// none is open yet. It never discards an open bucket itself; flush and
+// drainAll are the only places buckets are retired, so a bucket's Count/First
+// survive until the bucket is sent.
+func (d *DingTalkSink) coalesce(event *v1.Event) {
+	key := fmt.Sprintf("%s/%s", event.InvolvedObject.UID, event.Reason)
+	now := time.Now()
+
+	if ce, ok := d.pending[key]; ok {
+		ce.Count++
+		ce.Last = now
+		ce.Event = event
+		return
+	}
+	d.pending[key] = &coalescedEvent{Event: event, Count: 1, First: now, Last: now}
+}
+
+// flush sends every pending bucket that is ready, grouping up to d.MaxBatch
+// buckets into a single dingtalk message. A bucket is ready once it has gone
+// quiet for d.Window (no repeat within the window) or, for an object that
+// keeps repeating faster than that, once it has been open for d.Window -
+// either way the bucket is sent whole, so a sustained storm is reported in
+// successive windows instead of having its earlier occurrences silently
+// dropped when the next one arrives.
+func (d *DingTalkSink) flush() {
+	d.mu.Lock()
+	ready := collectReady(d.pending, d.Window, time.Now())
+	d.mu.Unlock()
+
+	d.sendBatches(ready)
+}
+
+// drainAll sends every pending bucket regardless of whether it has aged out
+// yet, so a channel's final flush on shutdown doesn't strand events that
+// arrived too recently to be "ready".
+func (d *DingTalkSink) drainAll() {
+	d.mu.Lock()
+	all := make([]*coalescedEvent, 0, len(d.pending))
+	for key, ce := range d.pending {
+		all = append(all, ce)
+		delete(d.pending, key)
+	}
+	d.mu.Unlock()
+
+	d.sendBatches(all)
+}
+
+// sendBatches groups buckets into d.MaxBatch-sized batches and sends each.
+func (d *DingTalkSink) sendBatches(buckets []*coalescedEvent) {
+	maxBatch := d.MaxBatch
+	if maxBatch <= 0 {
+		maxBatch = 1
+	}
+
+	for i := 0; i < len(buckets); i += maxBatch {
+		end := i + maxBatch
+		if end > len(buckets) {
+			end = len(buckets)
+		}
+		d.dingBatch(buckets[i:end])
+	}
+}
+
+// collectReady pulls every bucket out of pending that is ready to send as of
+// now, deleting it from pending as it goes. Split out of flush so the bucket
+// lifecycle can be tested without a live HTTP round trip.
+func collectReady(pending map[string]*coalescedEvent, window time.Duration, now time.Time) []*coalescedEvent {
+	ready := make([]*coalescedEvent, 0, len(pending))
+	for key, ce := range pending {
+		if now.Sub(ce.Last) >= window || now.Sub(ce.First) >= window {
+			ready = append(ready, ce)
+			delete(pending, key)
 		}
 	}
+	return ready
 }
 
 func (d *DingTalkSink) isEventLevelDangerous(level string) bool {
@@ -105,7 +282,8 @@ func (d *DingTalkSink) isEventLevelDangerous(level string) bool {
 	return false
 }
 
-func (d *DingTalkSink) Ding(event *v1.Event) {
+// shouldSkip applies the sink-level namespace/kind allow-lists.
+func (d *DingTalkSink) shouldSkip(event *v1.Event) bool {
 	if d.Namespaces != nil {
 		skip := true
 		for _, namespace := range d.Namespaces {
@@ -115,7 +293,7 @@ func (d *DingTalkSink) Ding(event *v1.Event) {
 			}
 		}
 		if skip {
-			return
+			return true
 		}
 	}
 
@@ -128,29 +306,143 @@ func (d *DingTalkSink) Ding(event *v1.Event) {
 			}
 		}
 		if skip {
-			return
+			return true
 		}
 	}
 
-	msg := createMsgFromEvent(d.Labels, event)
+	return false
+}
+
+// dingBatch sends one or more coalesced buckets as a single dingtalk message,
+// dropping any buckets the namespace/kind allow-lists filter out.
+func (d *DingTalkSink) dingBatch(batch []*coalescedEvent) {
+	filtered := make([]*coalescedEvent, 0, len(batch))
+	for _, ce := range batch {
+		if d.shouldSkip(ce.Event) {
+			continue
+		}
+		filtered = append(filtered, ce)
+	}
+	if len(filtered) == 0 {
+		return
+	}
+
+	msg := d.createMsgFromBatch(filtered)
 	if msg == nil {
-		klog.Warningf("failed to create msg from event,because of %v", event)
+		klog.Warningf("failed to create msg from batch,because of %v", filtered)
 		return
 	}
 
-	msg_bytes, err := json.Marshal(msg)
+	d.postWithBackoff(msg)
+}
+
+const (
+	maxPostRetries        = 5
+	dingTalkRateLimitCode = 130101
+)
+
+type dingTalkResp struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// postWithBackoff waits for the token bucket, then posts msg, retrying with
+// exponential backoff whenever dingtalk reports it is rate-limiting this robot
+// (HTTP 429 or errcode 130101).
+func (d *DingTalkSink) postWithBackoff(msg *DingTalkMsg) {
+	msgBytes, err := json.Marshal(msg)
 	if err != nil {
 		klog.Warningf("failed to marshal msg %v", msg)
 		return
 	}
 
-	b := bytes.NewBuffer(msg_bytes)
+	if d.Limiter != nil {
+		if err := d.Limiter.Wait(context.Background()); err != nil {
+			klog.Warningf("dingtalk rate limiter wait failed: %v", err)
+			return
+		}
+	}
 
-	resp, err := http.Post(fmt.Sprintf("https://%s?access_token=%s", d.Endpoint, d.Token), CONTENT_TYPE_JSON, b)
-	if err != nil || resp.StatusCode != http.StatusOK {
-		klog.Errorf("failed to send msg to dingtalk,because of %s resp code is %d", err.Error(), resp.StatusCode)
-		return
+	backoff := time.Second
+	for retry := 0; ; retry++ {
+		rateLimited, err := d.post(msgBytes)
+		if err != nil {
+			klog.Errorf("failed to send msg to dingtalk,because of %v", err)
+			return
+		}
+		if !rateLimited {
+			return
+		}
+		if retry == maxPostRetries {
+			break
+		}
+		klog.Warningf("dingtalk rate limit hit (HTTP 429 or errcode=%d), backing off %v before retry %d/%d", dingTalkRateLimitCode, backoff, retry+1, maxPostRetries)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	klog.Errorf("dropped dingtalk msg after %d retries due to rate limiting", maxPostRetries)
+}
+
+// post makes a single POST attempt, reporting whether dingtalk rejected it for
+// being rate-limited so the caller can back off and retry.
+func (d *DingTalkSink) post(msgBytes []byte) (rateLimited bool, err error) {
+	resp, err := http.Post(d.buildURL(), CONTENT_TYPE_JSON, bytes.NewBuffer(msgBytes))
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return true, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("resp code is %d", resp.StatusCode)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return false, nil
+	}
+
+	var dr dingTalkResp
+	if err := json.Unmarshal(body, &dr); err != nil {
+		return false, nil
+	}
+	if dr.ErrCode == dingTalkRateLimitCode {
+		return true, nil
+	}
+	if dr.ErrCode != 0 {
+		return false, fmt.Errorf("dingtalk rejected msg: errcode=%d errmsg=%s", dr.ErrCode, dr.ErrMsg)
+	}
+
+	return false, nil
+}
+
+// buildURL appends the webhook's access_token and, when the robot has the
+// "additional signing" security policy enabled, a timestamp/sign pair computed
+// per https://open.dingtalk.com/document/robots/customize-robot-security-settings.
+func (d *DingTalkSink) buildURL() string {
+	baseURL := fmt.Sprintf("https://%s?access_token=%s", d.Endpoint, d.Token)
+	if len(d.Secret) == 0 {
+		return baseURL
 	}
+
+	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
+	sign := signWebhook(d.Secret, timestamp)
+
+	return fmt.Sprintf("%s&timestamp=%d&sign=%s", baseURL, timestamp, url.QueryEscape(sign))
+}
+
+// signWebhook computes the base64 HMAC-SHA256 signature dingtalk's "additional
+// signing" security policy expects for a given secret and millisecond
+// timestamp, per
+// https://open.dingtalk.com/document/robots/customize-robot-security-settings
+func signWebhook(secret string, timestampMillis int64) string {
+	stringToSign := fmt.Sprintf("%d\n%s", timestampMillis, secret)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
 }
 
 func getLevel(level string) int {
@@ -166,21 +458,138 @@ func getLevel(level string) int {
 	return score
 }
 
-func createMsgFromEvent(labels []string, event *v1.Event) *DingTalkMsg {
-	msg := &DingTalkMsg{}
-	msg.MsgType = DEFAULT_MSG_TYPE
-	template := MSG_TEMPLATE
-	if len(labels) > 0 {
-		for _, label := range labels {
-			template = fmt.Sprintf(LABE_TEMPLATE, label) + template
+// createMsgFromBatch renders a coalesced batch into a single dingtalk message.
+// A lone, non-repeated event keeps the regular single-event rendering; a real
+// batch (more than one bucket, or a bucket that repeated) is rendered as a
+// markdown/actionCard card listing every bucket with its occurrence count.
+func (d *DingTalkSink) createMsgFromBatch(batch []*coalescedEvent) *DingTalkMsg {
+	if len(batch) == 1 && batch[0].Count == 1 {
+		return d.createMsgFromEvent(batch[0].Event)
+	}
+
+	msgType := d.MsgType
+	if msgType != MSG_TYPE_MARKDOWN && msgType != MSG_TYPE_ACTION_CARD {
+		msgType = MSG_TYPE_MARKDOWN
+	}
+
+	var buf strings.Builder
+	buf.WriteString("#### kube-eventer alerts\n")
+	for _, ce := range batch {
+		event := ce.Event
+		fmt.Fprintf(&buf, "- **%s/%s** %s: %s", event.Namespace, event.Name, event.Reason, event.Message)
+		if ce.Count > 1 {
+			fmt.Fprintf(&buf, " (x%d, %s - %s)", ce.Count, ce.First.Format(time.RFC3339), ce.Last.Format(time.RFC3339))
 		}
+		buf.WriteString("\n")
 	}
-	msg.Text = DingTalkText{
-		Content: fmt.Sprintf(template, event.Type, event.InvolvedObject.Kind, event.Namespace, event.Name, event.Reason, event.LastTimestamp.String(), event.Message),
+
+	msg := &DingTalkMsg{MsgType: msgType}
+	switch msgType {
+	case MSG_TYPE_ACTION_CARD:
+		msg.ActionCard = &DingTalkActionCard{Title: "kube-eventer alerts", Text: buf.String()}
+	default:
+		msg.Markdown = &DingTalkMarkdown{Title: "kube-eventer alerts", Text: buf.String()}
+	}
+
+	if len(d.AtMobiles) > 0 || d.AtAll {
+		msg.At = &DingTalkAt{AtMobiles: d.AtMobiles, IsAtAll: d.AtAll}
 	}
+
 	return msg
 }
 
+func (d *DingTalkSink) createMsgFromEvent(event *v1.Event) *DingTalkMsg {
+	msgType := d.MsgType
+	if len(msgType) == 0 {
+		msgType = DEFAULT_MSG_TYPE
+	}
+
+	content, err := d.renderContent(msgType, event)
+	if err != nil {
+		klog.Warningf("failed to render dingtalk msg,because of %v", err)
+		return nil
+	}
+
+	msg := &DingTalkMsg{MsgType: msgType}
+	switch msgType {
+	case MSG_TYPE_MARKDOWN:
+		msg.Markdown = &DingTalkMarkdown{Title: event.Reason, Text: content}
+	case MSG_TYPE_LINK:
+		linkURL, err := d.renderLinkURL(event)
+		if err != nil {
+			klog.Warningf("failed to render dingtalk link_url,because of %v", err)
+			return nil
+		}
+		msg.Link = &DingTalkLink{Title: event.Reason, Text: content, MessageUrl: linkURL}
+	case MSG_TYPE_ACTION_CARD:
+		msg.ActionCard = &DingTalkActionCard{Title: event.Reason, Text: content}
+	default:
+		msg.Text = &DingTalkText{Content: content}
+	}
+
+	if len(d.AtMobiles) > 0 || d.AtAll {
+		msg.At = &DingTalkAt{AtMobiles: d.AtMobiles, IsAtAll: d.AtAll}
+	}
+
+	return msg
+}
+
+// renderLinkURL executes d.LinkURL with event as its data to produce a
+// msg_type=link message's required messageUrl. NewDingTalkSink refuses to
+// build a msg_type=link sink without a LinkURL template, so this is only
+// reached when one is configured.
+func (d *DingTalkSink) renderLinkURL(event *v1.Event) (string, error) {
+	var buf bytes.Buffer
+	if err := d.LinkURL.Execute(&buf, event); err != nil {
+		return "", fmt.Errorf("failed to execute dingtalk link_url template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// renderContent builds the message body. With format=cloudevents the body is the
+// event's CloudEvents JSON envelope and any user template is not used. Otherwise,
+// when a user template is configured it is executed with the full event as its
+// data; failing that a built-in printf-style template is used, picked by msg type
+// so markdown/actionCard messages render nicely.
+func (d *DingTalkSink) renderContent(msgType string, event *v1.Event) (string, error) {
+	if d.Format == FORMAT_CLOUDEVENTS {
+		ce, err := eventformat.NewCloudEvent(event).JSON()
+		if err != nil {
+			return "", fmt.Errorf("failed to marshal event as cloudevents: %v", err)
+		}
+		if msgType == MSG_TYPE_MARKDOWN || msgType == MSG_TYPE_ACTION_CARD {
+			return fmt.Sprintf("```\n%s\n```", ce), nil
+		}
+		return string(ce), nil
+	}
+
+	if d.Template != nil {
+		var buf bytes.Buffer
+		if err := d.Template.Execute(&buf, event); err != nil {
+			return "", fmt.Errorf("failed to execute dingtalk template: %v", err)
+		}
+		return buf.String(), nil
+	}
+
+	tpl := MSG_TEMPLATE
+	if msgType == MSG_TYPE_MARKDOWN || msgType == MSG_TYPE_ACTION_CARD {
+		tpl = MARKDOWN_MSG_TEMPLATE
+		if len(d.Labels) > 0 {
+			for _, label := range d.Labels {
+				tpl = fmt.Sprintf(LABE_TEMPLATE, label) + tpl
+			}
+		}
+		return fmt.Sprintf(tpl, event.Reason, event.Type, event.InvolvedObject.Kind, event.Namespace, event.Name, event.LastTimestamp.String(), event.Message), nil
+	}
+
+	if len(d.Labels) > 0 {
+		for _, label := range d.Labels {
+			tpl = fmt.Sprintf(LABE_TEMPLATE, label) + tpl
+		}
+	}
+	return fmt.Sprintf(tpl, event.Type, event.InvolvedObject.Kind, event.Namespace, event.Name, event.Reason, event.LastTimestamp.String(), event.Message), nil
+}
+
 //func drawEventTableText(labels []string, event *v1.Event) string {
 //	tableString := &strings.Builder{}
 //	table := tablewriter.NewWriter(tableString)
@@ -218,6 +627,14 @@ func NewDingTalkSink(uri *url.URL) (*DingTalkSink, error) {
 	}
 	opts := uri.Query()
 
+	if len(opts["routes_file"]) >= 1 {
+		router, err := NewRouter(opts["routes_file"][0])
+		if err != nil {
+			return nil, err
+		}
+		return &DingTalkSink{Router: router}, nil
+	}
+
 	if len(opts["access_token"]) >= 1 {
 		d.Token = opts["access_token"][0]
 	} else {
@@ -233,6 +650,109 @@ func NewDingTalkSink(uri *url.URL) (*DingTalkSink, error) {
 		d.Labels = opts["label"]
 	}
 
+	d.MsgType = DEFAULT_MSG_TYPE
+	if len(opts["msg_type"]) >= 1 {
+		d.MsgType = opts["msg_type"][0]
+	}
+
+	tmplText := ""
+	if len(opts["template_file"]) >= 1 {
+		b, err := ioutil.ReadFile(opts["template_file"][0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dingtalk template_file %s: %v", opts["template_file"][0], err)
+		}
+		tmplText = string(b)
+	} else if len(opts["template"]) >= 1 {
+		tmplText = opts["template"][0]
+	}
+	if len(tmplText) > 0 {
+		tmpl, err := template.New("dingtalk").Parse(tmplText)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dingtalk template: %v", err)
+		}
+		d.Template = tmpl
+	}
+
+	linkURLText := ""
+	if len(opts["link_url_file"]) >= 1 {
+		b, err := ioutil.ReadFile(opts["link_url_file"][0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dingtalk link_url_file %s: %v", opts["link_url_file"][0], err)
+		}
+		linkURLText = string(b)
+	} else if len(opts["link_url"]) >= 1 {
+		linkURLText = opts["link_url"][0]
+	}
+	if len(linkURLText) > 0 {
+		tmpl, err := template.New("dingtalk-link-url").Parse(linkURLText)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse dingtalk link_url: %v", err)
+		}
+		d.LinkURL = tmpl
+	}
+	if d.MsgType == MSG_TYPE_LINK && d.LinkURL == nil {
+		return nil, fmt.Errorf("dingtalk msg_type=link requires link_url or link_url_file to be set")
+	}
+
+	if len(opts["at_mobiles"]) >= 1 {
+		d.AtMobiles = getValues(opts["at_mobiles"])
+	}
+	if len(opts["at_all"]) >= 1 {
+		d.AtAll = opts["at_all"][0] == "true"
+	}
+
+	if len(opts["secret_file"]) >= 1 {
+		b, err := ioutil.ReadFile(opts["secret_file"][0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dingtalk secret_file %s: %v", opts["secret_file"][0], err)
+		}
+		d.Secret = strings.TrimSpace(string(b))
+	} else if len(opts["secret"]) >= 1 {
+		d.Secret = opts["secret"][0]
+	}
+
+	d.Format = DEFAULT_FORMAT
+	if len(opts["format"]) >= 1 {
+		d.Format = opts["format"][0]
+	}
+
+	// default to dingtalk's own per-robot cap of 20 msg/min.
+	ratePerSec := 20.0 / 60.0
+	burst := 1
+	if len(opts["rate"]) >= 1 {
+		r, err := strconv.ParseFloat(opts["rate"][0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dingtalk rate %q: %v", opts["rate"][0], err)
+		}
+		ratePerSec = r
+	}
+	if len(opts["burst"]) >= 1 {
+		b, err := strconv.Atoi(opts["burst"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid dingtalk burst %q: %v", opts["burst"][0], err)
+		}
+		burst = b
+	}
+	d.Limiter = rate.NewLimiter(rate.Limit(ratePerSec), burst)
+
+	d.Window = 30 * time.Second
+	if len(opts["window"]) >= 1 {
+		w, err := time.ParseDuration(opts["window"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid dingtalk window %q: %v", opts["window"][0], err)
+		}
+		d.Window = w
+	}
+
+	d.MaxBatch = 1
+	if len(opts["max_batch"]) >= 1 {
+		mb, err := strconv.Atoi(opts["max_batch"][0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid dingtalk max_batch %q: %v", opts["max_batch"][0], err)
+		}
+		d.MaxBatch = mb
+	}
+
 	d.Namespaces = getValues(opts["namespaces"])
 	// kinds:https://github.com/kubernetes/community/blob/master/contributors/devel/sig-architecture/api-conventions.md#lists-and-simple-kinds
 	// such as node,pod,component and so on
@@ -242,10 +762,8 @@ func NewDingTalkSink(uri *url.URL) (*DingTalkSink, error) {
 }
 
 func getValues(o []string) []string {
-	if len(o) >= 1 {
-		if len(o[0]) == 0 {
-			return nil
-		}
+	if len(o) == 0 || len(o[0]) == 0 {
+		return nil
 	}
 	return strings.Split(o[0], ",")
 }
@@ -0,0 +1,217 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dingtalk
+
+import (
+	"net/url"
+	"testing"
+	"text/template"
+	"time"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func newTestEvent(uid, reason string) *v1.Event {
+	return &v1.Event{
+		InvolvedObject: v1.ObjectReference{UID: types.UID(uid)},
+		Reason:         reason,
+	}
+}
+
+// TestCoalesceAccumulatesWithoutLoss verifies repeated occurrences of the same
+// (UID, Reason) keep accumulating in one bucket instead of being reset, no
+// matter how many times coalesce is called while the bucket is still open.
+func TestCoalesceAccumulatesWithoutLoss(t *testing.T) {
+	d := &DingTalkSink{Window: 30 * time.Second, pending: make(map[string]*coalescedEvent)}
+
+	const occurrences = 6
+	for i := 0; i < occurrences; i++ {
+		d.coalesce(newTestEvent("pod-1", "CrashLoopBackOff"))
+	}
+
+	if len(d.pending) != 1 {
+		t.Fatalf("expected a single bucket, got %d", len(d.pending))
+	}
+	for _, ce := range d.pending {
+		if ce.Count != occurrences {
+			t.Fatalf("expected Count=%d, got %d", occurrences, ce.Count)
+		}
+	}
+}
+
+// TestCollectReadyDoesNotDropStormBucket reproduces the CrashLoopBackOff storm
+// scenario: a bucket that is still being actively repeated (Last is recent)
+// must still be flushed once it has been open for the full Window, and its
+// accumulated Count must be preserved in the returned bucket rather than
+// silently discarded.
+func TestCollectReadyDoesNotDropStormBucket(t *testing.T) {
+	window := 30 * time.Second
+	now := time.Now()
+	first := now.Add(-window) // bucket opened exactly one window ago
+	last := now.Add(-1 * time.Second)
+
+	pending := map[string]*coalescedEvent{
+		"pod-1/CrashLoopBackOff": {
+			Event: newTestEvent("pod-1", "CrashLoopBackOff"),
+			Count: 7,
+			First: first,
+			Last:  last,
+		},
+	}
+
+	ready := collectReady(pending, window, now)
+
+	if len(ready) != 1 {
+		t.Fatalf("expected the aged-out bucket to be flushed, got %d ready buckets", len(ready))
+	}
+	if ready[0].Count != 7 {
+		t.Fatalf("expected accumulated Count=7 to survive the flush, got %d", ready[0].Count)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("expected the bucket to be removed from pending after flush, still has %d", len(pending))
+	}
+}
+
+// TestCollectReadyKeepsFreshBucketPending ensures a bucket that neither went
+// quiet nor reached Window age is left untouched.
+func TestCollectReadyKeepsFreshBucketPending(t *testing.T) {
+	window := 30 * time.Second
+	now := time.Now()
+	pending := map[string]*coalescedEvent{
+		"pod-1/CrashLoopBackOff": {
+			Event: newTestEvent("pod-1", "CrashLoopBackOff"),
+			Count: 2,
+			First: now.Add(-5 * time.Second),
+			Last:  now.Add(-1 * time.Second),
+		},
+	}
+
+	ready := collectReady(pending, window, now)
+
+	if len(ready) != 0 {
+		t.Fatalf("expected the fresh bucket to stay pending, got %d ready", len(ready))
+	}
+	if len(pending) != 1 {
+		t.Fatalf("expected the bucket to remain in pending, got %d", len(pending))
+	}
+}
+
+// TestCollectReadyFlushesQuietBucket ensures a bucket whose object stopped
+// repeating is flushed, with its Count intact, once it has been quiet for
+// Window.
+func TestCollectReadyFlushesQuietBucket(t *testing.T) {
+	window := 30 * time.Second
+	now := time.Now()
+	pending := map[string]*coalescedEvent{
+		"pod-1/CrashLoopBackOff": {
+			Event: newTestEvent("pod-1", "CrashLoopBackOff"),
+			Count: 3,
+			First: now.Add(-31 * time.Second),
+			Last:  now.Add(-31 * time.Second),
+		},
+	}
+
+	ready := collectReady(pending, window, now)
+
+	if len(ready) != 1 || ready[0].Count != 3 {
+		t.Fatalf("expected the quiet bucket to flush with Count=3, got %+v", ready)
+	}
+}
+
+// TestSignWebhookKnownAnswer is a known-answer test for the HMAC-SHA256
+// webhook signing scheme documented at
+// https://open.dingtalk.com/document/robots/customize-robot-security-settings
+// The expected signature was computed independently (Python hmac/hashlib)
+// for timestamp=1609459200000 ("2021-01-01T00:00:00Z" in ms) and the secret
+// below, so a regression in the string-to-sign layout or digest/encoding
+// would be caught even if it still produced *a* valid-looking base64 string.
+func TestSignWebhookKnownAnswer(t *testing.T) {
+	const secret = "SEC000000000000000000000"
+	const timestamp int64 = 1609459200000
+	const want = "Hcokz1WcpNVSiYGxZQjmcO8kuKVRDRoxGZ3GsGnT6y8="
+
+	got := signWebhook(secret, timestamp)
+
+	if got != want {
+		t.Fatalf("signWebhook(%q, %d) = %q, want %q", secret, timestamp, got, want)
+	}
+}
+
+// TestGetValuesEmpty is a regression test: getValues used to fall through to
+// o[0] when len(o) == 0, so passing it nil (what a url.Values lookup returns
+// for a query param that was never set) or an empty []string panicked with
+// "index out of range".
+func TestGetValuesEmpty(t *testing.T) {
+	if got := getValues(nil); got != nil {
+		t.Fatalf("getValues(nil) = %v, want nil", got)
+	}
+	if got := getValues([]string{}); got != nil {
+		t.Fatalf("getValues([]string{}) = %v, want nil", got)
+	}
+}
+
+// TestNewDingTalkSinkWithoutNamespacesOrKinds is a regression test for the
+// panic in getValues: a webhook URL that omits namespaces=/kinds= (the norm,
+// and exactly what the routes_file doc example in router.go uses) must build
+// successfully instead of crashing on startup.
+func TestNewDingTalkSinkWithoutNamespacesOrKinds(t *testing.T) {
+	uri, _ := url.Parse("https://oapi.dingtalk.com/robot/send?access_token=tok")
+
+	d, err := NewDingTalkSink(uri)
+	if err != nil {
+		t.Fatalf("NewDingTalkSink() returned error: %v", err)
+	}
+	if d.Namespaces != nil {
+		t.Errorf("Namespaces = %v, want nil", d.Namespaces)
+	}
+	if d.Kinds != nil {
+		t.Errorf("Kinds = %v, want nil", d.Kinds)
+	}
+}
+
+// TestNewDingTalkSinkRequiresLinkURL ensures a msg_type=link sink cannot be
+// built without a link_url, since DingTalk rejects a link message whose
+// messageUrl is empty.
+func TestNewDingTalkSinkRequiresLinkURL(t *testing.T) {
+	uri, _ := url.Parse("https://oapi.dingtalk.com/robot/send?access_token=tok&msg_type=link")
+
+	if _, err := NewDingTalkSink(uri); err == nil {
+		t.Fatal("expected an error building a msg_type=link sink without link_url")
+	}
+}
+
+// TestCreateMsgFromEventPopulatesLinkURL ensures the link_url template is
+// rendered into the DingTalk link message's messageUrl field.
+func TestCreateMsgFromEventPopulatesLinkURL(t *testing.T) {
+	d := &DingTalkSink{
+		MsgType: MSG_TYPE_LINK,
+		LinkURL: template.Must(template.New("dingtalk-link-url").Parse("https://dashboard.example.com/{{.Namespace}}/{{.Name}}")),
+	}
+	event := &v1.Event{}
+	event.Namespace = "kube-system"
+	event.Name = "pod-1"
+	event.Reason = "CrashLoopBackOff"
+
+	msg := d.createMsgFromEvent(event)
+
+	if msg == nil || msg.Link == nil {
+		t.Fatalf("expected a link message, got %+v", msg)
+	}
+	const want = "https://dashboard.example.com/kube-system/pod-1"
+	if msg.Link.MessageUrl != want {
+		t.Fatalf("MessageUrl = %q, want %q", msg.Link.MessageUrl, want)
+	}
+}
@@ -0,0 +1,310 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dingtalk
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"gopkg.in/yaml.v2"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog"
+)
+
+/**
+routes_file format, referenced from a dingtalk sink URL as
+--sink:dingtalk:route?routes_file=/etc/kube-eventer/dingtalk-routes.yaml
+
+routes:
+  - webhook: "https://oapi.dingtalk.com/robot/send?access_token=xxx&level=Warning&msg_type=markdown"
+    namespace_regex: "^kube-system$"
+    min_level: Warning
+  - webhook: "https://oapi.dingtalk.com/robot/send?access_token=yyy&secret=zzz"
+    namespace_regex: "^app-team-"
+  - webhook: "https://oapi.dingtalk.com/robot/send?access_token=www&at_all=true"
+    reason_regex: "PolicyViolation"
+    label_selector: "team=security"
+
+Each webhook is a normal dingtalk sink URL and is parsed with NewDingTalkSink, so
+its options (msg_type, template, secret, rate, window, ...) work per-route: a
+channel coalesces and batches the events it receives through its sink's own
+window/max_batch settings, exactly as a single-webhook sink would. The webhook's
+level option is the exception: it is not applied to routed events, which are
+filtered by level through min_level only.
+The routing fields (namespace_regex, kind, reason_regex, min_level,
+label_selector) decide which routes an event is dispatched to.
+*/
+type RoutesConfig struct {
+	Routes []RouteConfig `yaml:"routes"`
+}
+
+type RouteConfig struct {
+	Webhook        string `yaml:"webhook"`
+	NamespaceRegex string `yaml:"namespace_regex"`
+	Kind           string `yaml:"kind"`
+	ReasonRegex    string `yaml:"reason_regex"`
+	MinLevel       string `yaml:"min_level"`
+	LabelSelector  string `yaml:"label_selector"`
+}
+
+// Router fans an event out to every Channel whose match rules it satisfies.
+// It implements core.EventSink so a DingTalkSink built from a routes_file can
+// be used as a drop-in replacement for a single-webhook sink.
+type Router struct {
+	channels []*channel
+	podIndex cache.Indexer
+	stopCh   chan struct{}
+}
+
+// channel is one route: a DingTalkSink plus the match rules that decide
+// whether a given event should be dispatched to it. Each channel drains its
+// own queue on its own goroutine so a slow/rate-limited route never blocks
+// the others.
+type channel struct {
+	sink      *DingTalkSink
+	namespace *regexp.Regexp
+	kind      string
+	reason    *regexp.Regexp
+	minLevel  int
+	selector  labels.Selector
+	queue     chan *v1.Event
+}
+
+// NewRouter loads routesFile and builds a Router with one Channel per route,
+// starting each channel's worker goroutine and, if any route matches on
+// labels, a shared Pod informer used to resolve the involved object's labels.
+func NewRouter(routesFile string) (*Router, error) {
+	b, err := ioutil.ReadFile(routesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dingtalk routes_file %s: %v", routesFile, err)
+	}
+
+	var cfg RoutesConfig
+	if err := yaml.Unmarshal(b, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse dingtalk routes_file %s: %v", routesFile, err)
+	}
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("dingtalk routes_file %s declares no routes", routesFile)
+	}
+
+	r := &Router{stopCh: make(chan struct{})}
+
+	for i, rc := range cfg.Routes {
+		ch, err := newChannel(rc)
+		if err != nil {
+			r.Stop()
+			return nil, fmt.Errorf("invalid dingtalk route #%d: %v", i, err)
+		}
+		r.channels = append(r.channels, ch)
+		go ch.run()
+	}
+
+	if needsLabelSelector(cfg.Routes) {
+		if err := r.startPodInformer(); err != nil {
+			r.Stop()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+func newChannel(rc RouteConfig) (*channel, error) {
+	webhookURL, err := url.Parse(rc.Webhook)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook %q: %v", rc.Webhook, err)
+	}
+
+	sink, err := NewDingTalkSink(webhookURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook %q: %v", rc.Webhook, err)
+	}
+
+	ch := &channel{sink: sink, kind: rc.Kind, queue: make(chan *v1.Event, 1000)}
+
+	if len(rc.NamespaceRegex) > 0 {
+		re, err := regexp.Compile(rc.NamespaceRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace_regex %q: %v", rc.NamespaceRegex, err)
+		}
+		ch.namespace = re
+	}
+
+	if len(rc.ReasonRegex) > 0 {
+		re, err := regexp.Compile(rc.ReasonRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid reason_regex %q: %v", rc.ReasonRegex, err)
+		}
+		ch.reason = re
+	}
+
+	if len(rc.MinLevel) > 0 {
+		ch.minLevel = getLevel(rc.MinLevel)
+	}
+
+	if len(rc.LabelSelector) > 0 {
+		sel, err := labels.Parse(rc.LabelSelector)
+		if err != nil {
+			return nil, fmt.Errorf("invalid label_selector %q: %v", rc.LabelSelector, err)
+		}
+		ch.selector = sel
+	}
+
+	return ch, nil
+}
+
+func needsLabelSelector(routes []RouteConfig) bool {
+	for _, rc := range routes {
+		if len(rc.LabelSelector) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// run drains the channel's queue on its own goroutine, coalescing events into
+// c.sink's pending buckets and flushing them on c.sink's own Window so the
+// channel's window/max_batch settings are honored exactly as they would be
+// for a single-webhook sink.
+func (c *channel) run() {
+	flushEvery := c.sink.Window
+	if flushEvery <= 0 {
+		flushEvery = 30 * time.Second
+	}
+	ticker := time.NewTicker(flushEvery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case event, ok := <-c.queue:
+			if !ok {
+				c.sink.drainAll()
+				return
+			}
+			if c.sink.shouldSkip(event) {
+				continue
+			}
+			c.sink.mu.Lock()
+			if c.sink.pending == nil {
+				c.sink.pending = make(map[string]*coalescedEvent)
+			}
+			c.sink.coalesce(event)
+			c.sink.mu.Unlock()
+		case <-ticker.C:
+			c.sink.flush()
+		}
+	}
+}
+
+// matches reports whether event satisfies every match rule configured on c.
+// involvedLabels is only populated for Pod involved objects; for any other kind
+// a label_selector is matched against an empty label set, so a selector that
+// requires a label never matches such events.
+func (c *channel) matches(event *v1.Event, involvedLabels labels.Set) bool {
+	if getLevel(event.Type) < c.minLevel {
+		return false
+	}
+	if c.namespace != nil && !c.namespace.MatchString(event.Namespace) {
+		return false
+	}
+	if len(c.kind) > 0 && c.kind != event.InvolvedObject.Kind {
+		return false
+	}
+	if c.reason != nil && !c.reason.MatchString(event.Reason) {
+		return false
+	}
+	if c.selector != nil && !c.selector.Matches(involvedLabels) {
+		return false
+	}
+	return true
+}
+
+func (r *Router) Name() string {
+	return DINGTALK_SINK
+}
+
+func (r *Router) Stop() {
+	close(r.stopCh)
+	for _, ch := range r.channels {
+		close(ch.queue)
+	}
+}
+
+func (r *Router) ExportEvents(batch *core.EventBatch) {
+	for _, event := range batch.Events {
+		involvedLabels := r.involvedObjectLabels(event)
+		for _, ch := range r.channels {
+			if !ch.matches(event, involvedLabels) {
+				continue
+			}
+			select {
+			case ch.queue <- event:
+			default:
+				klog.Warningf("dingtalk route channel queue full, dropping event %s/%s", event.Namespace, event.Name)
+			}
+		}
+	}
+}
+
+// involvedObjectLabels resolves the labels of event's involved object via the
+// shared Pod informer. Only Pod involved objects are supported today; for other
+// kinds it returns nil, so they never match a selector that requires a label.
+func (r *Router) involvedObjectLabels(event *v1.Event) labels.Set {
+	if r.podIndex == nil || event.InvolvedObject.Kind != "Pod" {
+		return nil
+	}
+
+	key := event.InvolvedObject.Namespace + "/" + event.InvolvedObject.Name
+	obj, exists, err := r.podIndex.GetByKey(key)
+	if err != nil || !exists {
+		return nil
+	}
+
+	pod, ok := obj.(*v1.Pod)
+	if !ok {
+		return nil
+	}
+	return pod.Labels
+}
+
+func (r *Router) startPodInformer() error {
+	cfg, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("dingtalk routing with label_selector requires in-cluster config: %v", err)
+	}
+
+	client, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to build kubernetes client for dingtalk routing: %v", err)
+	}
+
+	factory := informers.NewSharedInformerFactory(client, 0)
+	podInformer := factory.Core().V1().Pods().Informer()
+	r.podIndex = podInformer.GetIndexer()
+
+	factory.Start(r.stopCh)
+	factory.WaitForCacheSync(r.stopCh)
+
+	return nil
+}
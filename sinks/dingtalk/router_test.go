@@ -0,0 +1,131 @@
+// Copyright 2015 Google Inc. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dingtalk
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/AliyunContainerService/kube-eventer/core"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestChannelMatches(t *testing.T) {
+	rc := RouteConfig{
+		Webhook:        "https://oapi.dingtalk.com/robot/send?access_token=tok",
+		NamespaceRegex: "^kube-system$",
+		Kind:           "Pod",
+		ReasonRegex:    "^CrashLoopBackOff$",
+		MinLevel:       "Warning",
+		LabelSelector:  "team=security",
+	}
+	ch, err := newChannel(rc)
+	if err != nil {
+		t.Fatalf("newChannel() returned error: %v", err)
+	}
+
+	matchingEvent := func() *v1.Event {
+		e := &v1.Event{Namespace: "kube-system", Reason: "CrashLoopBackOff", Type: v1.EventTypeWarning}
+		e.InvolvedObject.Kind = "Pod"
+		return e
+	}
+
+	tests := []struct {
+		name    string
+		event   *v1.Event
+		labels  labels.Set
+		matches bool
+	}{
+		{"all rules satisfied", matchingEvent(), labels.Set{"team": "security"}, true},
+		{"wrong namespace", func() *v1.Event { e := matchingEvent(); e.Namespace = "default"; return e }(), labels.Set{"team": "security"}, false},
+		{"wrong kind", func() *v1.Event { e := matchingEvent(); e.InvolvedObject.Kind = "Node"; return e }(), labels.Set{"team": "security"}, false},
+		{"wrong reason", func() *v1.Event { e := matchingEvent(); e.Reason = "Started"; return e }(), labels.Set{"team": "security"}, false},
+		{"below min level", func() *v1.Event { e := matchingEvent(); e.Type = v1.EventTypeNormal; return e }(), labels.Set{"team": "security"}, false},
+		{"label selector does not match", matchingEvent(), labels.Set{"team": "infra"}, false},
+		{"no involved object labels", matchingEvent(), nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ch.matches(tt.event, tt.labels); got != tt.matches {
+				t.Errorf("matches() = %v, want %v", got, tt.matches)
+			}
+		})
+	}
+}
+
+// TestRouterExportEventsFanOut builds a Router's channels directly (bypassing
+// NewRouter's worker goroutines, so the queues can be inspected without a
+// race) and checks ExportEvents dispatches each event to exactly the channels
+// whose match rules it satisfies.
+func TestRouterExportEventsFanOut(t *testing.T) {
+	systemChannel, err := newChannel(RouteConfig{
+		Webhook:        "https://oapi.dingtalk.com/robot/send?access_token=tok1",
+		NamespaceRegex: "^kube-system$",
+	})
+	if err != nil {
+		t.Fatalf("newChannel() returned error: %v", err)
+	}
+	warningChannel, err := newChannel(RouteConfig{
+		Webhook:  "https://oapi.dingtalk.com/robot/send?access_token=tok2",
+		MinLevel: "Warning",
+	})
+	if err != nil {
+		t.Fatalf("newChannel() returned error: %v", err)
+	}
+	r := &Router{channels: []*channel{systemChannel, warningChannel}}
+
+	systemWarningEvent := &v1.Event{Namespace: "kube-system", Type: v1.EventTypeWarning}
+	defaultNormalEvent := &v1.Event{Namespace: "default", Type: v1.EventTypeNormal}
+
+	r.ExportEvents(&core.EventBatch{Events: []*v1.Event{systemWarningEvent, defaultNormalEvent}})
+
+	if got := len(systemChannel.queue); got != 1 {
+		t.Errorf("systemChannel received %d events, want 1", got)
+	}
+	if got := len(warningChannel.queue); got != 1 {
+		t.Errorf("warningChannel received %d events, want 1", got)
+	}
+	if queued := <-systemChannel.queue; queued != systemWarningEvent {
+		t.Error("systemChannel did not receive the kube-system event")
+	}
+	if queued := <-warningChannel.queue; queued != systemWarningEvent {
+		t.Error("warningChannel did not receive the warning event")
+	}
+}
+
+// TestNewRouterAcceptsNamespacelessWebhook is a regression test for the
+// routes_file doc example, which omits namespaces=/kinds= on every route:
+// NewDingTalkSink (and therefore NewRouter) must not panic when those
+// options are absent.
+func TestNewRouterAcceptsNamespacelessWebhook(t *testing.T) {
+	routesFile := filepath.Join(t.TempDir(), "routes.yaml")
+	contents := `
+routes:
+  - webhook: "https://oapi.dingtalk.com/robot/send?access_token=xxx&level=Warning&msg_type=markdown"
+    namespace_regex: "^kube-system$"
+`
+	if err := ioutil.WriteFile(routesFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("failed to write routes_file: %v", err)
+	}
+
+	r, err := NewRouter(routesFile)
+	if err != nil {
+		t.Fatalf("NewRouter() returned error: %v", err)
+	}
+	r.Stop()
+}